@@ -0,0 +1,153 @@
+package sops
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ChangeType identifies the kind of modification a Change describes.
+type ChangeType int
+
+const (
+	// ChangeInsert means the node at Path exists in the new tree but had no
+	// counterpart in the old one.
+	ChangeInsert ChangeType = iota
+	// ChangeDelete means the node at Path existed in the old tree but is
+	// absent from the new one.
+	ChangeDelete
+	// ChangeModify means the node at Path exists in both trees but its leaf
+	// value differs between them.
+	ChangeModify
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeInsert:
+		return "insert"
+	case ChangeDelete:
+		return "delete"
+	case ChangeModify:
+		return "modify"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a single difference found while walking two TreeBranch values
+// with Diff. Path is the canonical bracket-notation path to the affected
+// node, e.g. `["bar"]["foobar"][2]`, the same notation accepted by
+// TreeBranch.Truncate.
+type Change struct {
+	Type ChangeType
+	Path string
+	Old  interface{}
+	New  interface{}
+}
+
+// Differ walks two TreeBranch values in parallel, keyed at each level by
+// TreeItem.Key, and reports the Change records needed to turn Old into New.
+// A key present on only one side is reported as an insert or a delete; a
+// key present on both sides with differing leaf values is reported as a
+// modify. TreeBranch children are recursed into rather than treated as
+// opaque leaves, mirroring the tree-diff approach used by go-git's
+// difftree.
+//
+// The zero value is ready to use; set IgnoreMetadataOnly or PathPrefix to
+// narrow what gets reported.
+type Differ struct {
+	// IgnoreMetadataOnly skips the top-level "sops" key, so that
+	// re-encrypting a file with a rotated data key doesn't show up as
+	// noise when reviewing what a human actually changed.
+	IgnoreMetadataOnly bool
+	// PathPrefix, if set, restricts the report to changes whose Path
+	// starts with this bracket-notation prefix.
+	PathPrefix string
+}
+
+// Diff walks a and b with the zero-value Differ. It's a convenience for
+// the common case of comparing two whole trees with no options.
+func Diff(a, b TreeBranch) ([]Change, error) {
+	return Differ{}.Diff(a, b)
+}
+
+// Diff walks a and b according to the Differ's options and returns the
+// changes needed to turn a into b.
+func (d Differ) Diff(a, b TreeBranch) ([]Change, error) {
+	var changes []Change
+	if err := d.diffBranch("", a, b, &changes); err != nil {
+		return nil, err
+	}
+	if d.PathPrefix == "" {
+		return changes, nil
+	}
+	filtered := make([]Change, 0, len(changes))
+	for _, c := range changes {
+		if strings.HasPrefix(c.Path, d.PathPrefix) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered, nil
+}
+
+func (d Differ) diffBranch(path string, a, b TreeBranch, changes *[]Change) error {
+	bIndex := make(map[string]int, len(b))
+	for i, item := range b {
+		bIndex[item.Key] = i
+	}
+	seen := make(map[string]bool, len(a))
+	for _, aItem := range a {
+		if d.IgnoreMetadataOnly && path == "" && aItem.Key == "sops" {
+			continue
+		}
+		seen[aItem.Key] = true
+		childPath := pathAppendKey(path, aItem.Key)
+		bi, present := bIndex[aItem.Key]
+		if !present {
+			*changes = append(*changes, Change{Type: ChangeDelete, Path: childPath, Old: aItem.Value})
+			continue
+		}
+		if err := d.diffValue(childPath, aItem.Value, b[bi].Value, changes); err != nil {
+			return err
+		}
+	}
+	for _, bItem := range b {
+		if seen[bItem.Key] {
+			continue
+		}
+		if d.IgnoreMetadataOnly && path == "" && bItem.Key == "sops" {
+			continue
+		}
+		*changes = append(*changes, Change{Type: ChangeInsert, Path: pathAppendKey(path, bItem.Key), New: bItem.Value})
+	}
+	return nil
+}
+
+func (d Differ) diffValue(path string, oldValue, newValue interface{}, changes *[]Change) error {
+	oldBranch, oldIsBranch := oldValue.(TreeBranch)
+	newBranch, newIsBranch := newValue.(TreeBranch)
+	if oldIsBranch && newIsBranch {
+		return d.diffBranch(path, oldBranch, newBranch, changes)
+	}
+	if oldIsBranch != newIsBranch {
+		*changes = append(*changes, Change{Type: ChangeModify, Path: path, Old: oldValue, New: newValue})
+		return nil
+	}
+	if !valuesEqual(oldValue, newValue) {
+		*changes = append(*changes, Change{Type: ChangeModify, Path: path, Old: oldValue, New: newValue})
+	}
+	return nil
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if fmt.Sprintf("%T", a) != fmt.Sprintf("%T", b) {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// pathAppendKey extends a bracket-notation path with a map key, matching
+// the notation accepted by TreeBranch.Truncate.
+func pathAppendKey(parent, key string) string {
+	return parent + "[\"" + key + "\"]"
+}
@@ -0,0 +1,162 @@
+package sops
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"fmt"
+)
+
+// ProofStep is one level of a Proof: the hash of the sibling subtree at
+// that level, and whether that sibling sits to the left of the node on
+// the path from leaf to root.
+type ProofStep struct {
+	Hash []byte
+	Left bool
+}
+
+// Proof is an inclusion proof that a leaf at a given path was present in
+// the tree that produced a particular MerkleRoot. It's the ordered list
+// of sibling hashes from the leaf up to the root, one ProofStep per
+// level, so VerifyProof can recompute the root without needing the rest
+// of the tree.
+type Proof []ProofStep
+
+type merkleLeaf struct {
+	path string
+	hash []byte
+}
+
+// MerkleRoot computes the root of a binary Merkle tree built over the
+// tree's leaves in canonical (pre-order) walk order. Each leaf is hashed
+// as H(0x00 || path || value) and internal nodes as H(0x01 || left ||
+// right), duplicating the last hash at a level that has no pair. This is
+// the same construction used by Certificate Transparency and similar
+// append-only log designs, and lets an auditor verify that a specific
+// encrypted value belongs to a specific sops file version without
+// possessing the decryption keys.
+func (t Tree) MerkleRoot(hash crypto.Hash) ([]byte, error) {
+	leaves, err := merkleLeaves(t.Branch, "", hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("can't compute a Merkle root of an empty tree")
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = l.hash
+	}
+	for len(level) > 1 {
+		level = merkleLevelUp(level, hash)
+	}
+	return level[0], nil
+}
+
+// GenerateProof returns an inclusion proof for the leaf at path, which
+// must be in the same bracket-notation format accepted by
+// TreeBranch.Truncate. hash must be the same crypto.Hash passed to
+// MerkleRoot when the root being proven against was computed; the proof
+// is verified against that root with VerifyProof.
+func (t Tree) GenerateProof(path string, hash crypto.Hash) (Proof, error) {
+	leaves, err := merkleLeaves(t.Branch, "", hash)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, l := range leaves {
+		if l.path == path {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("no leaf found at path %s", path)
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = l.hash
+	}
+	var proof Proof
+	for len(level) > 1 {
+		var sibling []byte
+		var left bool
+		if index%2 == 0 {
+			if index+1 < len(level) {
+				sibling = level[index+1]
+			} else {
+				sibling = level[index]
+			}
+			left = false
+		} else {
+			sibling = level[index-1]
+			left = true
+		}
+		proof = append(proof, ProofStep{Hash: sibling, Left: left})
+		level = merkleLevelUp(level, hash)
+		index = index / 2
+	}
+	return proof, nil
+}
+
+// VerifyProof recomputes the Merkle root from leaf using proof and
+// reports whether it matches root, proving that leaf was present at path
+// in the tree that produced root without requiring the rest of the tree
+// or the decryption key. hash must be the same crypto.Hash used to
+// produce root via MerkleRoot/GenerateProof.
+func VerifyProof(root []byte, path string, leaf interface{}, proof Proof, hash crypto.Hash) (bool, error) {
+	current := hashLeaf(hash, path, leaf)
+	for _, step := range proof {
+		if step.Left {
+			current = hashNode(hash, step.Hash, current)
+		} else {
+			current = hashNode(hash, current, step.Hash)
+		}
+	}
+	return bytes.Equal(current, root), nil
+}
+
+func merkleLeaves(branch TreeBranch, path string, hash crypto.Hash) ([]merkleLeaf, error) {
+	var leaves []merkleLeaf
+	for _, item := range branch {
+		childPath := pathAppendKey(path, item.Key)
+		if child, ok := item.Value.(TreeBranch); ok {
+			childLeaves, err := merkleLeaves(child, childPath, hash)
+			if err != nil {
+				return nil, err
+			}
+			leaves = append(leaves, childLeaves...)
+			continue
+		}
+		leaves = append(leaves, merkleLeaf{path: childPath, hash: hashLeaf(hash, childPath, item.Value)})
+	}
+	return leaves, nil
+}
+
+func merkleLevelUp(level [][]byte, hash crypto.Hash) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, hashNode(hash, level[i], level[i+1]))
+		} else {
+			next = append(next, hashNode(hash, level[i], level[i]))
+		}
+	}
+	return next
+}
+
+func hashLeaf(hash crypto.Hash, path string, value interface{}) []byte {
+	h := hash.New()
+	h.Write([]byte{0x00})
+	h.Write([]byte(path))
+	h.Write([]byte(fmt.Sprintf("%v", value)))
+	return h.Sum(nil)
+}
+
+func hashNode(hash crypto.Hash, left, right []byte) []byte {
+	h := hash.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
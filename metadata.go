@@ -0,0 +1,44 @@
+package sops
+
+import "go.mozilla.org/sops/transparency"
+
+// TransparencyProof is a stored record of one append to a transparency
+// log: the inclusion proof and signed tree head that together prove a
+// given Tree version is part of that log, kept so VerifyTransparency can
+// re-check them without the log having to still be reachable.
+type TransparencyProof struct {
+	InclusionProof transparency.InclusionProof
+	SignedTreeHead transparency.SignedTreeHead
+}
+
+// Metadata holds the non-leaf information sops stores alongside a Tree:
+// which keys protect its data key, and how leaves are selected for
+// encryption.
+type Metadata struct {
+	KeySources []KeySource
+
+	// UnencryptedSuffix leaves a leaf unencrypted when its key ends in
+	// this suffix. At most one of UnencryptedSuffix, EncryptedSuffix,
+	// UnencryptedRegex, and EncryptedRegex may be set; see SelectionMode.
+	UnencryptedSuffix string
+	// EncryptedSuffix encrypts a leaf only when its key ends in this
+	// suffix, leaving everything else unencrypted.
+	EncryptedSuffix string
+	// UnencryptedRegex leaves a leaf unencrypted when this pattern
+	// matches its full dotted path.
+	UnencryptedRegex string
+	// EncryptedRegex encrypts a leaf only when this pattern matches its
+	// full dotted path, leaving everything else unencrypted.
+	EncryptedRegex string
+
+	// MerkleRoot is the tree's Merkle root as of the last encrypt, computed
+	// by Tree.MerkleRoot under the hash named by MerkleHash.
+	MerkleRoot []byte
+	// MerkleHash names the crypto.Hash used to produce MerkleRoot, as
+	// returned by crypto.Hash.String(), e.g. "SHA-256".
+	MerkleHash string
+
+	// TransparencyProofs records one TransparencyProof per append of this
+	// file's versions to a transparency log, most recent last.
+	TransparencyProofs []TransparencyProof
+}
@@ -0,0 +1,384 @@
+package sops
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PathComponent is one segment of a Path: either a map key (MapKey) or a
+// slice index (Index).
+type PathComponent interface {
+	isPathComponent()
+	String() string
+}
+
+// MapKey is a PathComponent that selects a TreeItem by key.
+type MapKey string
+
+func (MapKey) isPathComponent() {}
+func (k MapKey) String() string { return escapePathKey(string(k)) }
+
+// Index is a PathComponent that selects an element of a slice value by
+// position.
+type Index int
+
+func (Index) isPathComponent() {}
+func (i Index) String() string { return strconv.Itoa(int(i)) }
+
+// Path is a parsed, typed path into a TreeBranch, such as the components
+// of `bar.foobar[2]` or its bracket-notation equivalent
+// `["bar"]["foobar"][2]`. Prefer Path over hand-built path strings: it
+// parses each notation once, escapes keys correctly, and round-trips
+// through String().
+type Path []PathComponent
+
+// String renders p in canonical dot notation, escaping any key that
+// contains a dot, bracket, or backslash.
+func (p Path) String() string {
+	var b strings.Builder
+	for i, c := range p {
+		switch v := c.(type) {
+		case MapKey:
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(v.String())
+		case Index:
+			b.WriteByte('[')
+			b.WriteString(v.String())
+			b.WriteByte(']')
+		}
+	}
+	return b.String()
+}
+
+func escapePathKey(key string) string {
+	r := strings.NewReplacer(`\`, `\\`, `.`, `\.`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(key)
+}
+
+// ParseDotPath parses a path in dot notation, e.g. `bar.foobar[2]`. A key
+// containing a literal dot, bracket, or backslash must escape it with a
+// backslash, e.g. `a\.b`.
+func ParseDotPath(s string) (Path, error) {
+	var path Path
+	var key strings.Builder
+	flushKey := func() {
+		if key.Len() > 0 {
+			path = append(path, MapKey(key.String()))
+			key.Reset()
+		}
+	}
+	escaped := false
+	inIndex := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			key.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case inIndex:
+			if r == ']' {
+				n, err := strconv.Atoi(key.String())
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q in path %q: %w", key.String(), s, err)
+				}
+				path = append(path, Index(n))
+				key.Reset()
+				inIndex = false
+				continue
+			}
+			key.WriteRune(r)
+		case r == '.':
+			flushKey()
+		case r == '[':
+			flushKey()
+			inIndex = true
+		default:
+			key.WriteRune(r)
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("path %q ends with a trailing escape", s)
+	}
+	if inIndex {
+		return nil, fmt.Errorf("path %q has an unterminated index", s)
+	}
+	flushKey()
+	return path, nil
+}
+
+// ParseBracketPath parses a path in the legacy bracket notation used by
+// Truncate, e.g. `["bar"]["foobar"][2]`.
+func ParseBracketPath(s string) (Path, error) {
+	var path Path
+	rest := s
+	for len(rest) > 0 {
+		if rest[0] != '[' {
+			return nil, fmt.Errorf("expected '[' in path %q", s)
+		}
+		end := strings.IndexByte(rest, ']')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated '[' in path %q", s)
+		}
+		token := rest[1:end]
+		if len(token) >= 2 && token[0] == '"' && token[len(token)-1] == '"' {
+			path = append(path, MapKey(token[1:len(token)-1]))
+		} else {
+			n, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path segment %q in path %q: %w", token, s, err)
+			}
+			path = append(path, Index(n))
+		}
+		rest = rest[end+1:]
+	}
+	return path, nil
+}
+
+// Get returns the value at path within branch, or an error if any
+// component of path doesn't resolve.
+func (branch TreeBranch) Get(path Path) (interface{}, error) {
+	var current interface{} = branch
+	var walked Path
+	for _, c := range path {
+		walked = append(walked, c)
+		switch comp := c.(type) {
+		case MapKey:
+			b, ok := current.(TreeBranch)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a branch", walked[:len(walked)-1])
+			}
+			item, ok := b.lookup(string(comp))
+			if !ok {
+				return nil, fmt.Errorf("no value found at path %s", walked)
+			}
+			current = item
+		case Index:
+			rv := reflect.ValueOf(current)
+			if rv.Kind() != reflect.Slice {
+				return nil, fmt.Errorf("%s is not a list", walked[:len(walked)-1])
+			}
+			if int(comp) < 0 || int(comp) >= rv.Len() {
+				return nil, fmt.Errorf("index out of range at path %s", walked)
+			}
+			current = rv.Index(int(comp)).Interface()
+		}
+	}
+	return current, nil
+}
+
+func (branch TreeBranch) lookup(key string) (interface{}, bool) {
+	for _, item := range branch {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set returns a copy of branch with value placed at path, creating
+// intermediate TreeBranch values as needed. Existing values elsewhere in
+// the tree are left untouched. Unlike Get, every component of path must
+// be a MapKey: Set has no way to grow or replace an element of an
+// existing slice value, so an Index anywhere in path is rejected.
+func (branch TreeBranch) Set(path Path, value interface{}) (TreeBranch, error) {
+	if len(path) == 0 {
+		return branch, fmt.Errorf("path must have at least one component")
+	}
+	key, ok := path[0].(MapKey)
+	if !ok {
+		return branch, fmt.Errorf("the first path component must be a map key")
+	}
+	if len(path) == 1 {
+		return insertOrReplace(branch, string(key), value), nil
+	}
+	for i, item := range branch {
+		if item.Key != string(key) {
+			continue
+		}
+		child, ok := item.Value.(TreeBranch)
+		if !ok {
+			return branch, fmt.Errorf("%s is not a branch", MapKey(key))
+		}
+		newChild, err := child.Set(path[1:], value)
+		if err != nil {
+			return branch, err
+		}
+		out := append(TreeBranch{}, branch...)
+		out[i] = TreeItem{Key: item.Key, Value: newChild}
+		return out, nil
+	}
+	newChild, err := (TreeBranch{}).Set(path[1:], value)
+	if err != nil {
+		return branch, err
+	}
+	return append(append(TreeBranch{}, branch...), TreeItem{Key: string(key), Value: newChild}), nil
+}
+
+func insertOrReplace(branch TreeBranch, key string, value interface{}) TreeBranch {
+	for i, item := range branch {
+		if item.Key == key {
+			out := append(TreeBranch{}, branch...)
+			out[i] = TreeItem{Key: key, Value: value}
+			return out
+		}
+	}
+	return append(append(TreeBranch{}, branch...), TreeItem{Key: key, Value: value})
+}
+
+// InsertOrReplaceValue replaces the top-level item with the given key,
+// or appends one if none exists. It behaves like Set with a
+// single-component Path; use Set to reach a nested path.
+func (branch TreeBranch) InsertOrReplaceValue(key string, value interface{}) TreeBranch {
+	return insertOrReplace(branch, key, value)
+}
+
+// Truncate returns the value found at path, given in the legacy bracket
+// notation (e.g. `["bar"]["foobar"][2]`). It's a thin wrapper around
+// ParseBracketPath and Get kept so existing callers don't have to
+// construct a Path themselves.
+func (branch TreeBranch) Truncate(path string) (interface{}, error) {
+	p, err := ParseBracketPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return branch.Get(p)
+}
+
+// Delete returns a copy of branch with the item at path removed. As with
+// Set, every component of path must be a MapKey; Delete can't remove an
+// element of a slice value, so an Index anywhere in path is rejected.
+func (branch TreeBranch) Delete(path Path) (TreeBranch, error) {
+	if len(path) == 0 {
+		return branch, fmt.Errorf("path must have at least one component")
+	}
+	key, ok := path[0].(MapKey)
+	if !ok {
+		return branch, fmt.Errorf("the first path component must be a map key")
+	}
+	for i, item := range branch {
+		if item.Key != string(key) {
+			continue
+		}
+		if len(path) == 1 {
+			out := append(TreeBranch{}, branch[:i]...)
+			return append(out, branch[i+1:]...), nil
+		}
+		child, ok := item.Value.(TreeBranch)
+		if !ok {
+			return branch, fmt.Errorf("%s is not a branch", MapKey(key))
+		}
+		newChild, err := child.Delete(path[1:])
+		if err != nil {
+			return branch, err
+		}
+		out := append(TreeBranch{}, branch...)
+		out[i] = TreeItem{Key: item.Key, Value: newChild}
+		return out, nil
+	}
+	return branch, fmt.Errorf("no value found at path %s", path)
+}
+
+// TreeIterEntry is one leaf yielded by a TreeIter walk.
+type TreeIterEntry struct {
+	Path  Path
+	Value interface{}
+}
+
+// TreeIter walks the leaves of a TreeBranch in pre-order, optionally
+// restricted by TreeIterOptions.
+type TreeIter struct {
+	entries []TreeIterEntry
+	pos     int
+}
+
+// TreeIterOptions narrows a TreeIter walk.
+type TreeIterOptions struct {
+	// PathPrefix, if non-empty, restricts the walk to leaves at or below
+	// this path.
+	PathPrefix Path
+	// EncryptedOnly, if true, skips leaves that Metadata's selection mode
+	// wouldn't encrypt.
+	EncryptedOnly bool
+	// Metadata supplies the selection mode EncryptedOnly filters by,
+	// mirroring whichever of UnencryptedSuffix, EncryptedSuffix,
+	// UnencryptedRegex, or EncryptedRegex the tree is configured with.
+	Metadata Metadata
+}
+
+// NewTreeIter builds a TreeIter over branch's leaves according to opts.
+func NewTreeIter(branch TreeBranch, opts TreeIterOptions) (*TreeIter, error) {
+	it := &TreeIter{}
+	if err := it.walk(branch, nil, opts); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func (it *TreeIter) walk(branch TreeBranch, prefix Path, opts TreeIterOptions) error {
+	for _, item := range branch {
+		path := append(append(Path{}, prefix...), MapKey(item.Key))
+		if child, ok := item.Value.(TreeBranch); ok {
+			if opts.EncryptedOnly {
+				mode, err := opts.Metadata.SelectionMode()
+				if err != nil {
+					return err
+				}
+				if mode == SelectionUnencryptedSuffix {
+					include, err := shouldEncryptLeaf(opts.Metadata, path.String(), item.Key)
+					if err != nil {
+						return err
+					}
+					if !include {
+						continue
+					}
+				}
+			}
+			if err := it.walk(child, path, opts); err != nil {
+				return err
+			}
+			continue
+		}
+		if opts.EncryptedOnly {
+			encrypt, err := shouldEncryptLeaf(opts.Metadata, path.String(), item.Key)
+			if err != nil {
+				return err
+			}
+			if !encrypt {
+				continue
+			}
+		}
+		if len(opts.PathPrefix) > 0 && !pathHasPrefix(path, opts.PathPrefix) {
+			continue
+		}
+		it.entries = append(it.entries, TreeIterEntry{Path: path, Value: item.Value})
+	}
+	return nil
+}
+
+func pathHasPrefix(path, prefix Path) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, c := range prefix {
+		if path[i].String() != c.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// Next returns the next leaf in the walk. The final return value is
+// false once the walk is exhausted.
+func (it *TreeIter) Next() (Path, interface{}, bool) {
+	if it.pos >= len(it.entries) {
+		return nil, nil, false
+	}
+	e := it.entries[it.pos]
+	it.pos++
+	return e.Path, e.Value, true
+}
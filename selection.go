@@ -0,0 +1,130 @@
+package sops
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// compiledRegexes caches regexp.Compile results keyed by pattern, since
+// shouldEncryptLeaf is called once per leaf and a tree walk would
+// otherwise recompile the same UnencryptedRegex/EncryptedRegex pattern
+// for every leaf it visits.
+var compiledRegexes sync.Map
+
+func compileCached(pattern string) (*regexp.Regexp, error) {
+	if v, ok := compiledRegexes.Load(pattern); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledRegexes.Store(pattern, re)
+	return re, nil
+}
+
+// SelectionMode identifies which field of Metadata governs whether a
+// given leaf gets encrypted.
+type SelectionMode int
+
+const (
+	// SelectionUnencryptedSuffix leaves a leaf unencrypted when its key
+	// ends in Metadata.UnencryptedSuffix; this is the long-standing
+	// default behavior.
+	SelectionUnencryptedSuffix SelectionMode = iota
+	// SelectionEncryptedSuffix encrypts a leaf only when its key ends in
+	// Metadata.EncryptedSuffix, leaving everything else unencrypted.
+	SelectionEncryptedSuffix
+	// SelectionUnencryptedRegex leaves a leaf unencrypted when
+	// Metadata.UnencryptedRegex matches its full dotted path.
+	SelectionUnencryptedRegex
+	// SelectionEncryptedRegex encrypts a leaf only when
+	// Metadata.EncryptedRegex matches its full dotted path, leaving
+	// everything else unencrypted.
+	SelectionEncryptedRegex
+)
+
+// SelectionMode reports which selector m is configured to use. At most
+// one of UnencryptedSuffix, EncryptedSuffix, UnencryptedRegex, and
+// EncryptedRegex may be set; SelectionMode returns an error if more than
+// one is.
+// With none set, it defaults to SelectionUnencryptedSuffix against the
+// empty suffix, matching the historical behavior of encrypting every
+// leaf.
+func (m Metadata) SelectionMode() (SelectionMode, error) {
+	mode := SelectionUnencryptedSuffix
+	set := 0
+	if m.UnencryptedSuffix != "" {
+		mode = SelectionUnencryptedSuffix
+		set++
+	}
+	if m.EncryptedSuffix != "" {
+		mode = SelectionEncryptedSuffix
+		set++
+	}
+	if m.UnencryptedRegex != "" {
+		mode = SelectionUnencryptedRegex
+		set++
+	}
+	if m.EncryptedRegex != "" {
+		mode = SelectionEncryptedRegex
+		set++
+	}
+	if set > 1 {
+		return 0, fmt.Errorf("only one of UnencryptedSuffix, EncryptedSuffix, UnencryptedRegex or EncryptedRegex may be set")
+	}
+	return mode, nil
+}
+
+// shouldEncryptLeaf reports whether the leaf with the given key at the
+// given full dotted path should be encrypted under m's selection mode.
+// It's the decision point the recursive Encrypt/Decrypt walk consults in
+// place of the old unconditional strings.HasSuffix(key,
+// m.UnencryptedSuffix) check, so that a parent branch matching a regex
+// doesn't implicitly decide the fate of its children: every leaf is
+// evaluated against its own full path.
+func shouldEncryptLeaf(m Metadata, path, key string) (bool, error) {
+	mode, err := m.SelectionMode()
+	if err != nil {
+		return false, err
+	}
+	switch mode {
+	case SelectionUnencryptedSuffix:
+		if m.UnencryptedSuffix == "" {
+			// No suffix configured: every leaf is encrypted, matching the
+			// historical default before selection modes existed.
+			return true, nil
+		}
+		return !strings.HasSuffix(key, m.UnencryptedSuffix), nil
+	case SelectionEncryptedSuffix:
+		return strings.HasSuffix(key, m.EncryptedSuffix), nil
+	case SelectionUnencryptedRegex:
+		re, err := compileCached(m.UnencryptedRegex)
+		if err != nil {
+			return false, fmt.Errorf("invalid UnencryptedRegex %q: %w", m.UnencryptedRegex, err)
+		}
+		return !re.MatchString(path), nil
+	case SelectionEncryptedRegex:
+		re, err := compileCached(m.EncryptedRegex)
+		if err != nil {
+			return false, fmt.Errorf("invalid EncryptedRegex %q: %w", m.EncryptedRegex, err)
+		}
+		return re.MatchString(path), nil
+	default:
+		return false, fmt.Errorf("unknown selection mode %d", mode)
+	}
+}
+
+// dotPath joins a parent dotted path and a child key for the purposes of
+// UnencryptedRegex/EncryptedRegex matching, escaping the key the same way
+// Path.String() does so a literal dot inside a key can't be confused with
+// a path separator.
+func dotPath(parent, key string) string {
+	escaped := escapePathKey(key)
+	if parent == "" {
+		return escaped
+	}
+	return parent + "." + escaped
+}
@@ -0,0 +1,155 @@
+package sops
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// cipher encrypts and decrypts individual leaf values under the tree's
+// data key. Concrete implementations live in sibling packages (aes,
+// pgp) as well as test doubles; the interface is unexported because
+// callers always pass a concrete implementation and never need to name
+// the interface itself.
+type cipher interface {
+	Encrypt(value interface{}, key []byte, path string, stash interface{}) (string, error)
+	Decrypt(value string, key []byte, path string) (plaintext interface{}, stashValue interface{}, err error)
+}
+
+// Encrypt walks the tree in place, replacing each leaf value selected by
+// tree.Metadata's selection mode with its ciphertext under c, and
+// returns a hex-encoded hash of the encrypted values that callers can
+// use as an integrity check. stash, if non-nil, is unused by Encrypt but
+// kept symmetric with Decrypt's signature. On success it also records the
+// tree's Merkle root (over the now-encrypted leaves) in
+// tree.Metadata.MerkleRoot/MerkleHash, so the returned tree is ready to
+// hand to VerifyTransparency or a third-party auditor.
+func (tree *Tree) Encrypt(key []byte, c cipher, stash map[string][]interface{}) (string, error) {
+	h := sha256.New()
+	if err := tree.Metadata.walkEncrypt(tree.Branch, "", key, c, h); err != nil {
+		return "", err
+	}
+	root, err := tree.MerkleRoot(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("computing Merkle root after encrypt: %w", err)
+	}
+	tree.Metadata.MerkleRoot = root
+	tree.Metadata.MerkleHash = crypto.SHA256.String()
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Decrypt walks the tree in place, replacing each leaf value selected by
+// tree.Metadata's selection mode with its plaintext under c, and returns
+// a hex-encoded hash of the ciphertext values it decrypted. If stash is
+// non-nil, any stash value a cipher returns for a leaf is recorded under
+// that leaf's dotted path.
+func (tree *Tree) Decrypt(key []byte, c cipher, stash map[string][]interface{}) (string, error) {
+	h := sha256.New()
+	if err := tree.Metadata.walkDecrypt(tree.Branch, "", key, c, stash, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// walkEncrypt recurses through branch, consulting shouldEncryptLeaf at
+// every leaf. Only SelectionUnencryptedSuffix skips recursing into a
+// branch whose own key fails the selector, preserving the historical
+// behavior of `["foo_unencrypted"]` excluding everything beneath it.
+// Every other mode, including SelectionEncryptedSuffix, always descends:
+// a branch key not ending in EncryptedSuffix says nothing about whether
+// its children's keys do, so skipping the subtree there would silently
+// leave leaves that should be encrypted in plaintext. Regex modes
+// likewise evaluate every leaf independently of its ancestors, since a
+// regex match against one path says nothing about a different path.
+func (m Metadata) walkEncrypt(branch TreeBranch, path string, key []byte, c cipher, h hash.Hash) error {
+	mode, err := m.SelectionMode()
+	if err != nil {
+		return err
+	}
+	bySuffix := mode == SelectionUnencryptedSuffix
+	for i, item := range branch {
+		itemPath := dotPath(path, item.Key)
+		if child, ok := item.Value.(TreeBranch); ok {
+			if bySuffix {
+				include, err := shouldEncryptLeaf(m, itemPath, item.Key)
+				if err != nil {
+					return err
+				}
+				if !include {
+					continue
+				}
+			}
+			if err := m.walkEncrypt(child, itemPath, key, c, h); err != nil {
+				return err
+			}
+			continue
+		}
+		encrypt, err := shouldEncryptLeaf(m, itemPath, item.Key)
+		if err != nil {
+			return err
+		}
+		if !encrypt {
+			continue
+		}
+		ciphertext, err := c.Encrypt(item.Value, key, itemPath, nil)
+		if err != nil {
+			return fmt.Errorf("encrypting value at %s: %w", itemPath, err)
+		}
+		branch[i].Value = ciphertext
+		h.Write([]byte(itemPath))
+		h.Write([]byte(fmt.Sprintf("%v", ciphertext)))
+	}
+	return nil
+}
+
+// walkDecrypt mirrors walkEncrypt's traversal and descent rules but runs
+// c.Decrypt on each selected leaf.
+func (m Metadata) walkDecrypt(branch TreeBranch, path string, key []byte, c cipher, stash map[string][]interface{}, h hash.Hash) error {
+	mode, err := m.SelectionMode()
+	if err != nil {
+		return err
+	}
+	bySuffix := mode == SelectionUnencryptedSuffix
+	for i, item := range branch {
+		itemPath := dotPath(path, item.Key)
+		if child, ok := item.Value.(TreeBranch); ok {
+			if bySuffix {
+				include, err := shouldEncryptLeaf(m, itemPath, item.Key)
+				if err != nil {
+					return err
+				}
+				if !include {
+					continue
+				}
+			}
+			if err := m.walkDecrypt(child, itemPath, key, c, stash, h); err != nil {
+				return err
+			}
+			continue
+		}
+		decrypt, err := shouldEncryptLeaf(m, itemPath, item.Key)
+		if err != nil {
+			return err
+		}
+		if !decrypt {
+			continue
+		}
+		ciphertext, ok := item.Value.(string)
+		if !ok {
+			return fmt.Errorf("expected string ciphertext at %s, got %T", itemPath, item.Value)
+		}
+		plaintext, stashValue, err := c.Decrypt(ciphertext, key, itemPath)
+		if err != nil {
+			return fmt.Errorf("decrypting value at %s: %w", itemPath, err)
+		}
+		branch[i].Value = plaintext
+		if stash != nil && stashValue != nil {
+			stash[itemPath] = append(stash[itemPath], stashValue)
+		}
+		h.Write([]byte(itemPath))
+		h.Write([]byte(ciphertext))
+	}
+	return nil
+}
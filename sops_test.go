@@ -2,11 +2,16 @@ package sops
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	_ "crypto/sha512"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"go.mozilla.org/sops/kms"
+	"go.mozilla.org/sops/transparency"
 )
 
 type Cipher struct{}
@@ -254,6 +259,378 @@ func TestRemoveMasterKeys(t *testing.T) {
 	}, m.KeySources[0].Keys)
 }
 
+func TestDiffInsertDeleteModify(t *testing.T) {
+	a := TreeBranch{
+		TreeItem{Key: "foo", Value: "bar"},
+		TreeItem{Key: "baz", Value: "qux"},
+	}
+	b := TreeBranch{
+		TreeItem{Key: "foo", Value: "changed"},
+		TreeItem{Key: "quux", Value: "new"},
+	}
+	changes, err := Diff(a, b)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Change{
+		{Type: ChangeModify, Path: `["foo"]`, Old: "bar", New: "changed"},
+		{Type: ChangeDelete, Path: `["baz"]`, Old: "qux"},
+		{Type: ChangeInsert, Path: `["quux"]`, New: "new"},
+	}, changes)
+}
+
+func TestDiffNestedBranch(t *testing.T) {
+	a := TreeBranch{
+		TreeItem{Key: "bar", Value: TreeBranch{
+			TreeItem{Key: "foobar", Value: 1},
+		}},
+	}
+	b := TreeBranch{
+		TreeItem{Key: "bar", Value: TreeBranch{
+			TreeItem{Key: "foobar", Value: 2},
+		}},
+	}
+	changes, err := Diff(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Type: ChangeModify, Path: `["bar"]["foobar"]`, Old: 1, New: 2},
+	}, changes)
+}
+
+func TestDiffIgnoreMetadataOnly(t *testing.T) {
+	a := TreeBranch{
+		TreeItem{Key: "sops", Value: TreeBranch{TreeItem{Key: "mac", Value: "old"}}},
+		TreeItem{Key: "foo", Value: "bar"},
+	}
+	b := TreeBranch{
+		TreeItem{Key: "sops", Value: TreeBranch{TreeItem{Key: "mac", Value: "new"}}},
+		TreeItem{Key: "foo", Value: "bar"},
+	}
+	changes, err := Differ{IgnoreMetadataOnly: true}.Diff(a, b)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestDiffPathPrefix(t *testing.T) {
+	a := TreeBranch{
+		TreeItem{Key: "foo", Value: "bar"},
+		TreeItem{Key: "baz", Value: TreeBranch{TreeItem{Key: "qux", Value: 1}}},
+	}
+	b := TreeBranch{
+		TreeItem{Key: "foo", Value: "changed"},
+		TreeItem{Key: "baz", Value: TreeBranch{TreeItem{Key: "qux", Value: 2}}},
+	}
+	changes, err := Differ{PathPrefix: `["baz"]`}.Diff(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Type: ChangeModify, Path: `["baz"]["qux"]`, Old: 1, New: 2},
+	}, changes)
+}
+
+func TestMerkleRootAndProof(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "foo", Value: "bar"},
+		TreeItem{Key: "baz", Value: TreeBranch{
+			TreeItem{Key: "qux", Value: "quux"},
+		}},
+	}
+	tree := Tree{Branch: branch}
+
+	root, err := tree.MerkleRoot(crypto.SHA256)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, root)
+
+	proof, err := tree.GenerateProof(`["baz"]["qux"]`, crypto.SHA256)
+	assert.NoError(t, err)
+	ok, err := VerifyProof(root, `["baz"]["qux"]`, "quux", proof, crypto.SHA256)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// A different hash algorithm must produce a different root, and a
+	// proof generated under one algorithm must not verify against a root
+	// computed under another.
+	root512, err := tree.MerkleRoot(crypto.SHA512)
+	assert.NoError(t, err)
+	assert.NotEqual(t, root, root512)
+	ok, err = VerifyProof(root512, `["baz"]["qux"]`, "quux", proof, crypto.SHA256)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Tampering with the leaf value must invalidate the proof.
+	ok, err = VerifyProof(root, `["baz"]["qux"]`, "tampered", proof, crypto.SHA256)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestEncryptPersistsMerkleRoot(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "foo_unencrypted", Value: "bar"},
+	}
+	tree := Tree{Branch: branch, Metadata: Metadata{UnencryptedSuffix: "_unencrypted"}}
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), Cipher{}, nil)
+	assert.NoError(t, err)
+
+	want, err := tree.MerkleRoot(crypto.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, want, tree.Metadata.MerkleRoot)
+	assert.Equal(t, crypto.SHA256.String(), tree.Metadata.MerkleHash)
+}
+
+func TestEncryptAndLogVerifyTransparency(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	signer := transparency.Ed25519Signer{KeyID: "test", PrivateKey: priv}
+	log, err := transparency.NewFileLog(filepath.Join(t.TempDir(), "log"), signer)
+	assert.NoError(t, err)
+
+	branch := TreeBranch{TreeItem{Key: "foo_unencrypted", Value: "bar"}}
+	tree := Tree{Branch: branch, Metadata: Metadata{UnencryptedSuffix: "_unencrypted"}}
+
+	_, err = tree.EncryptAndLog(bytes.Repeat([]byte("f"), 32), Cipher{}, nil, "foo.yaml", log)
+	assert.NoError(t, err)
+	assert.Len(t, tree.Metadata.TransparencyProofs, 1)
+
+	err = tree.VerifyTransparency("foo.yaml", []ed25519.PublicKey{pub})
+	assert.NoError(t, err)
+
+	// A signed tree head from an untrusted key must not verify.
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	err = tree.VerifyTransparency("foo.yaml", []ed25519.PublicKey{otherPub})
+	assert.Error(t, err)
+}
+
+func TestEncryptedSuffix(t *testing.T) {
+	m := Metadata{EncryptedSuffix: "_encrypted"}
+	encrypt, err := shouldEncryptLeaf(m, dotPath("", "foo_encrypted"), "foo_encrypted")
+	assert.NoError(t, err)
+	assert.True(t, encrypt)
+	encrypt, err = shouldEncryptLeaf(m, dotPath("", "foo"), "foo")
+	assert.NoError(t, err)
+	assert.False(t, encrypt)
+}
+
+func TestUnencryptedRegex(t *testing.T) {
+	m := Metadata{UnencryptedRegex: `^metadata\.`}
+	encrypt, err := shouldEncryptLeaf(m, "metadata.foo", "foo")
+	assert.NoError(t, err)
+	assert.False(t, encrypt)
+	encrypt, err = shouldEncryptLeaf(m, "metadata.nested.foo", "foo")
+	assert.NoError(t, err)
+	assert.False(t, encrypt)
+	encrypt, err = shouldEncryptLeaf(m, "password", "password")
+	assert.NoError(t, err)
+	assert.True(t, encrypt)
+}
+
+func TestEncryptedRegex(t *testing.T) {
+	m := Metadata{EncryptedRegex: `(^|\.)(password|token)$`}
+	encrypt, err := shouldEncryptLeaf(m, "password", "password")
+	assert.NoError(t, err)
+	assert.True(t, encrypt)
+	// Matching is against each leaf's own full path: "auth.token" matches,
+	// while its sibling "auth.username" under the same parent doesn't.
+	encrypt, err = shouldEncryptLeaf(m, "auth.token", "token")
+	assert.NoError(t, err)
+	assert.True(t, encrypt)
+	encrypt, err = shouldEncryptLeaf(m, "auth.username", "username")
+	assert.NoError(t, err)
+	assert.False(t, encrypt)
+}
+
+func TestSelectionModeConflict(t *testing.T) {
+	m := Metadata{UnencryptedSuffix: "_unencrypted", EncryptedSuffix: "_encrypted"}
+	_, err := m.SelectionMode()
+	assert.Error(t, err)
+}
+
+func TestSelectionModeDefault(t *testing.T) {
+	encrypt, err := shouldEncryptLeaf(Metadata{}, "foo", "foo")
+	assert.NoError(t, err)
+	assert.True(t, encrypt)
+}
+
+func TestEncryptedSuffixRoundTrip(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "foo_encrypted", Value: "bar"},
+		TreeItem{Key: "baz", Value: "qux"},
+	}
+	tree := Tree{Branch: branch, Metadata: Metadata{EncryptedSuffix: "_encrypted"}}
+	cipher := Cipher{}
+
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), cipher, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "foo_encrypted", Value: reverse("bar")},
+		TreeItem{Key: "baz", Value: "qux"},
+	}, tree.Branch)
+
+	_, err = tree.Decrypt(bytes.Repeat([]byte("f"), 32), cipher, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "foo_encrypted", Value: "bar"},
+		TreeItem{Key: "baz", Value: "qux"},
+	}, tree.Branch)
+}
+
+func TestEncryptedSuffixNestedIndependence(t *testing.T) {
+	// "auth" itself doesn't end in the suffix, but unlike
+	// SelectionUnencryptedSuffix that must not exclude its children
+	// wholesale: EncryptedSuffix has to recurse into every branch so a
+	// child ending in the suffix still gets encrypted.
+	branch := TreeBranch{
+		TreeItem{Key: "auth", Value: TreeBranch{
+			TreeItem{Key: "username", Value: "alice"},
+			TreeItem{Key: "password_encrypted", Value: "secret"},
+		}},
+	}
+	tree := Tree{Branch: branch, Metadata: Metadata{EncryptedSuffix: "_encrypted"}}
+	cipher := Cipher{}
+
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), cipher, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "auth", Value: TreeBranch{
+			TreeItem{Key: "username", Value: "alice"},
+			TreeItem{Key: "password_encrypted", Value: reverse("secret")},
+		}},
+	}, tree.Branch)
+
+	_, err = tree.Decrypt(bytes.Repeat([]byte("f"), 32), cipher, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "auth", Value: TreeBranch{
+			TreeItem{Key: "username", Value: "alice"},
+			TreeItem{Key: "password_encrypted", Value: "secret"},
+		}},
+	}, tree.Branch)
+}
+
+func TestUnencryptedRegexRoundTrip(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "metadata", Value: TreeBranch{
+			TreeItem{Key: "owner", Value: "alice"},
+		}},
+		TreeItem{Key: "password", Value: "hunter2"},
+	}
+	tree := Tree{Branch: branch, Metadata: Metadata{UnencryptedRegex: `^metadata\.`}}
+	cipher := Cipher{}
+
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), cipher, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "metadata", Value: TreeBranch{
+			TreeItem{Key: "owner", Value: "alice"},
+		}},
+		TreeItem{Key: "password", Value: reverse("hunter2")},
+	}, tree.Branch)
+}
+
+func TestEncryptedRegexNestedIndependence(t *testing.T) {
+	// "auth" itself doesn't match the regex, but unlike
+	// SelectionUnencryptedSuffix that shouldn't exclude its children
+	// wholesale: each child is still
+	// evaluated against its own full path.
+	branch := TreeBranch{
+		TreeItem{Key: "auth", Value: TreeBranch{
+			TreeItem{Key: "username", Value: "alice"},
+			TreeItem{Key: "token", Value: "abc123"},
+		}},
+	}
+	tree := Tree{Branch: branch, Metadata: Metadata{EncryptedRegex: `(^|\.)token$`}}
+	cipher := Cipher{}
+
+	_, err := tree.Encrypt(bytes.Repeat([]byte("f"), 32), cipher, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, TreeBranch{
+		TreeItem{Key: "auth", Value: TreeBranch{
+			TreeItem{Key: "username", Value: "alice"},
+			TreeItem{Key: "token", Value: reverse("abc123")},
+		}},
+	}, tree.Branch)
+}
+
+func TestParseDotPathRoundTrip(t *testing.T) {
+	p, err := ParseDotPath(`bar.foobar[2]`)
+	assert.NoError(t, err)
+	assert.Equal(t, Path{MapKey("bar"), MapKey("foobar"), Index(2)}, p)
+	assert.Equal(t, `bar.foobar[2]`, p.String())
+}
+
+func TestParseDotPathEscapedKey(t *testing.T) {
+	p, err := ParseDotPath(`a\.b.c`)
+	assert.NoError(t, err)
+	assert.Equal(t, Path{MapKey("a.b"), MapKey("c")}, p)
+	assert.Equal(t, `a\.b.c`, p.String())
+}
+
+func TestParseBracketPath(t *testing.T) {
+	p, err := ParseBracketPath(`["bar"]["foobar"][2]`)
+	assert.NoError(t, err)
+	assert.Equal(t, Path{MapKey("bar"), MapKey("foobar"), Index(2)}, p)
+}
+
+func TestPathGetSetDeleteNested(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "foo", Value: 2},
+	}
+	p, err := ParseDotPath("bar.foobar")
+	assert.NoError(t, err)
+
+	branch, err = branch.Set(p, "baz")
+	assert.NoError(t, err)
+	v, err := branch.Get(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "baz", v)
+
+	branch, err = branch.Delete(p)
+	assert.NoError(t, err)
+	_, err = branch.Get(p)
+	assert.Error(t, err)
+}
+
+func TestPathGetMissing(t *testing.T) {
+	branch := TreeBranch{TreeItem{Key: "foo", Value: 2}}
+	p, err := ParseDotPath("bar")
+	assert.NoError(t, err)
+	_, err = branch.Get(p)
+	assert.Error(t, err)
+}
+
+func TestTreeIterFilters(t *testing.T) {
+	branch := TreeBranch{
+		TreeItem{Key: "foo_unencrypted", Value: "a"},
+		TreeItem{Key: "bar", Value: TreeBranch{
+			TreeItem{Key: "baz", Value: "b"},
+			TreeItem{Key: "qux", Value: "c"},
+		}},
+	}
+
+	it, err := NewTreeIter(branch, TreeIterOptions{
+		EncryptedOnly: true,
+		Metadata:      Metadata{UnencryptedSuffix: "_unencrypted"},
+	})
+	assert.NoError(t, err)
+	var got []string
+	for {
+		p, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		got = append(got, p.String()+"="+v.(string))
+	}
+	assert.Equal(t, []string{"bar.baz=b", "bar.qux=c"}, got)
+
+	prefix, err := ParseDotPath("bar.baz")
+	assert.NoError(t, err)
+	it, err = NewTreeIter(branch, TreeIterOptions{PathPrefix: prefix})
+	assert.NoError(t, err)
+	p, v, ok := it.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "bar.baz", p.String())
+	assert.Equal(t, "b", v)
+	_, _, ok = it.Next()
+	assert.False(t, ok)
+}
+
 func TestInsertOrReplaceValue(t *testing.T) {
 	tree := TreeBranch{
 		TreeItem{
@@ -0,0 +1,164 @@
+package transparency
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileLog is a Log backed by a local append-only file, one JSON-encoded
+// Entry per line. It's meant for single-writer setups (a CI job, a
+// single operator's workstation); sharing the log across writers needs
+// an external lock or a different Log implementation.
+type FileLog struct {
+	path   string
+	signer Signer
+
+	mu      sync.Mutex
+	entries []Entry
+	leaves  [][]byte
+}
+
+// NewFileLog opens (creating if necessary) the log file at path and
+// replays its existing entries before returning, so that Append and
+// InclusionProof see the log's full history.
+func NewFileLog(path string, signer Signer) (*FileLog, error) {
+	l := &FileLog{path: path, signer: signer}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening transparency log %s: %w", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("parsing transparency log %s: %w", path, err)
+		}
+		l.entries = append(l.entries, e)
+		l.leaves = append(l.leaves, LeafHash(e))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading transparency log %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Append implements Log. It computes the new signed tree head against a
+// candidate leaf set before touching the file or l's in-memory state, so
+// that a signing failure (e.g. the configured Signer erroring) leaves
+// both untouched rather than durably appending an entry whose STH was
+// never produced; a caller can then safely retry the same entry.
+func (l *FileLog) Append(entry Entry) (int64, SignedTreeHead, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	candidateLeaves := append(append([][]byte(nil), l.leaves...), LeafHash(entry))
+	sth, err := l.signedTreeHead(candidateLeaves)
+	if err != nil {
+		return 0, SignedTreeHead{}, err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, SignedTreeHead{}, fmt.Errorf("encoding transparency entry: %w", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, SignedTreeHead{}, fmt.Errorf("opening transparency log %s: %w", l.path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return 0, SignedTreeHead{}, fmt.Errorf("appending to transparency log %s: %w", l.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, SignedTreeHead{}, fmt.Errorf("syncing transparency log %s: %w", l.path, err)
+	}
+
+	index := int64(len(l.entries))
+	l.entries = append(l.entries, entry)
+	l.leaves = candidateLeaves
+	return index, sth, nil
+}
+
+// InclusionProof implements Log.
+func (l *FileLog) InclusionProof(index int64) (InclusionProof, SignedTreeHead, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if index < 0 || index >= int64(len(l.leaves)) {
+		return InclusionProof{}, SignedTreeHead{}, fmt.Errorf("index %d out of range for log of size %d", index, len(l.leaves))
+	}
+
+	level := append([][]byte(nil), l.leaves...)
+	i := index
+	var hashes [][]byte
+	for len(level) > 1 {
+		var sibling []byte
+		if i%2 == 0 {
+			if int(i)+1 < len(level) {
+				sibling = level[i+1]
+			} else {
+				sibling = level[i]
+			}
+		} else {
+			sibling = level[i-1]
+		}
+		hashes = append(hashes, sibling)
+		level = logLevelUp(level)
+		i = i / 2
+	}
+
+	sth, err := l.signedTreeHead(l.leaves)
+	if err != nil {
+		return InclusionProof{}, SignedTreeHead{}, err
+	}
+	return InclusionProof{LeafIndex: index, TreeSize: int64(len(l.leaves)), Hashes: hashes}, sth, nil
+}
+
+func (l *FileLog) signedTreeHead(leaves [][]byte) (SignedTreeHead, error) {
+	level := append([][]byte(nil), leaves...)
+	var root []byte
+	if len(level) == 0 {
+		root = sha256Sum(nil)
+	} else {
+		for len(level) > 1 {
+			level = logLevelUp(level)
+		}
+		root = level[0]
+	}
+	sth := SignedTreeHead{Size: int64(len(leaves)), RootHash: root, Timestamp: time.Now()}
+	sig, keyID, err := l.signer.Sign(signableBytes(sth))
+	if err != nil {
+		return SignedTreeHead{}, fmt.Errorf("signing tree head: %w", err)
+	}
+	sth.Signature = sig
+	sth.KeyID = keyID
+	return sth, nil
+}
+
+func logLevelUp(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		h := sha256.New()
+		h.Write([]byte{0x01})
+		h.Write(level[i])
+		if i+1 < len(level) {
+			h.Write(level[i+1])
+		} else {
+			h.Write(level[i])
+		}
+		next = append(next, h.Sum(nil))
+	}
+	return next
+}
+
+func sha256Sum(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
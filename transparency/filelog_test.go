@@ -0,0 +1,66 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) Ed25519Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	return Ed25519Signer{KeyID: "test", PrivateKey: priv}
+}
+
+type failingSigner struct{}
+
+func (failingSigner) Sign([]byte) ([]byte, string, error) {
+	return nil, "", errors.New("signing failed")
+}
+
+func TestFileLogAppendAndInclusionProof(t *testing.T) {
+	signer := newTestSigner(t)
+	log, err := NewFileLog(filepath.Join(t.TempDir(), "log"), signer)
+	if err != nil {
+		t.Fatalf("NewFileLog: %s", err)
+	}
+
+	entry := Entry{FileID: "foo.yaml", MerkleRoot: []byte("root1"), Timestamp: time.Now()}
+	index, sth, err := log.Append(entry)
+	if err != nil {
+		t.Fatalf("Append: %s", err)
+	}
+	if index != 0 {
+		t.Errorf("got index %d, want 0", index)
+	}
+	if sth.Size != 1 {
+		t.Errorf("got STH size %d, want 1", sth.Size)
+	}
+
+	proof, proofSTH, err := log.InclusionProof(index)
+	if err != nil {
+		t.Fatalf("InclusionProof: %s", err)
+	}
+	if proof.TreeSize != 1 || proofSTH.Size != 1 {
+		t.Errorf("unexpected proof/STH: %+v %+v", proof, proofSTH)
+	}
+}
+
+func TestFileLogAppendSigningFailureDoesNotMutateState(t *testing.T) {
+	log, err := NewFileLog(filepath.Join(t.TempDir(), "log"), failingSigner{})
+	if err != nil {
+		t.Fatalf("NewFileLog: %s", err)
+	}
+
+	if _, _, err := log.Append(Entry{FileID: "foo.yaml", MerkleRoot: []byte("root1")}); err == nil {
+		t.Fatal("expected Append to fail when the signer errors")
+	}
+	if len(log.entries) != 0 || len(log.leaves) != 0 {
+		t.Errorf("Append left state mutated after a signing failure: %d entries, %d leaves", len(log.entries), len(log.leaves))
+	}
+}
@@ -0,0 +1,104 @@
+// Package transparency provides an append-only, tamper-evident log of
+// encrypted file versions. sops.Tree.EncryptAndLog encrypts a tree and
+// appends an Entry recording its Merkle root to a Log in one step;
+// sops.Tree.VerifyTransparency checks that the file's data hasn't been
+// rewritten since the logged encrypt, using only the proofs stored in the
+// file's Metadata. It doesn't consult the Log, so a file rolled back
+// together with its Metadata to an older logged version still verifies.
+// Decrypt doesn't call it. Log and Signer are the extension points a caller
+// supplies; FileLog and Ed25519Signer are the provided implementations.
+package transparency
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"time"
+)
+
+// Entry is a single record appended to a Log: the identity of the file,
+// the Merkle root of its tree at the time of the encrypt, when that
+// happened, and the fingerprints of the master keys it was encrypted to.
+type Entry struct {
+	FileID                string
+	MerkleRoot            []byte
+	Timestamp             time.Time
+	KeySourceFingerprints []string
+}
+
+// SignedTreeHead is a signed commitment to the state of a Log at a given
+// size, analogous to a Certificate Transparency STH.
+type SignedTreeHead struct {
+	Size      int64
+	RootHash  []byte
+	Timestamp time.Time
+	KeyID     string
+	Signature []byte
+}
+
+// InclusionProof proves that the entry at LeafIndex is included in the
+// log at TreeSize, via the sibling hashes from that leaf up to the root
+// recorded in the corresponding SignedTreeHead.
+type InclusionProof struct {
+	LeafIndex int64
+	TreeSize  int64
+	Hashes    [][]byte
+}
+
+// Log is an append-only log of Entry records. Implementations must never
+// allow an already-appended entry to be altered or removed; Append only
+// ever grows the log. sops.Tree.EncryptAndLog is the intended caller of
+// Append; a Log with no caller wired to it never records anything.
+type Log interface {
+	// Append adds entry to the log and returns its index together with
+	// the SignedTreeHead committing to the log's new state.
+	Append(entry Entry) (index int64, sth SignedTreeHead, err error)
+	// InclusionProof returns a proof that the entry at index is part of
+	// the log, together with the SignedTreeHead it proves inclusion
+	// against.
+	InclusionProof(index int64) (proof InclusionProof, sth SignedTreeHead, err error)
+}
+
+// Signer produces the signature over a SignedTreeHead's signable bytes.
+// Ed25519Signer is the provided ed25519 implementation.
+type Signer interface {
+	// Sign returns the signature over message and the key ID that a
+	// verifier should use to look up the matching public key.
+	Sign(message []byte) (signature []byte, keyID string, err error)
+}
+
+// Ed25519Signer signs with a fixed ed25519 private key, identifying
+// itself to verifiers by KeyID exactly as the caller set it.
+type Ed25519Signer struct {
+	KeyID      string
+	PrivateKey ed25519.PrivateKey
+}
+
+// Sign implements Signer.
+func (s Ed25519Signer) Sign(message []byte) ([]byte, string, error) {
+	return ed25519.Sign(s.PrivateKey, message), s.KeyID, nil
+}
+
+// LeafHash returns the hash of entry as it appears as a leaf in a Log's
+// Merkle tree, so that a verifier holding an Entry and an InclusionProof
+// can recompute the same leaf a Log implementation used to build the
+// tree the proof was generated from.
+func LeafHash(entry Entry) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write([]byte(entry.FileID))
+	h.Write(entry.MerkleRoot)
+	return h.Sum(nil)
+}
+
+// signableBytes returns the canonical bytes a SignedTreeHead's signature
+// is computed over, so signing and verification agree on the encoding.
+func signableBytes(sth SignedTreeHead) []byte {
+	b := make([]byte, 0, len(sth.RootHash)+16)
+	b = append(b, byte(sth.Size>>56), byte(sth.Size>>48), byte(sth.Size>>40), byte(sth.Size>>32),
+		byte(sth.Size>>24), byte(sth.Size>>16), byte(sth.Size>>8), byte(sth.Size))
+	ts := sth.Timestamp.UnixNano()
+	b = append(b, byte(ts>>56), byte(ts>>48), byte(ts>>40), byte(ts>>32),
+		byte(ts>>24), byte(ts>>16), byte(ts>>8), byte(ts))
+	b = append(b, sth.RootHash...)
+	return b
+}
@@ -0,0 +1,52 @@
+package sops
+
+import (
+	"fmt"
+	"time"
+
+	"go.mozilla.org/sops/transparency"
+)
+
+// EncryptAndLog behaves like Encrypt, then appends a transparency.Entry
+// for the resulting Merkle root to log, identifying the file by fileID
+// and recording the fingerprint of each master key in tree.Metadata's
+// KeySources. The returned inclusion proof and signed tree head are
+// appended to tree.Metadata.TransparencyProofs so a later
+// VerifyTransparency call can check them without log still being
+// reachable.
+func (tree *Tree) EncryptAndLog(key []byte, c cipher, stash map[string][]interface{}, fileID string, log transparency.Log) (string, error) {
+	digest, err := tree.Encrypt(key, c, stash)
+	if err != nil {
+		return "", err
+	}
+
+	entry := transparency.Entry{
+		FileID:                fileID,
+		MerkleRoot:            tree.Metadata.MerkleRoot,
+		Timestamp:             time.Now(),
+		KeySourceFingerprints: keySourceFingerprints(tree.Metadata.KeySources),
+	}
+	index, sth, err := log.Append(entry)
+	if err != nil {
+		return "", fmt.Errorf("appending to transparency log: %w", err)
+	}
+	proof, _, err := log.InclusionProof(index)
+	if err != nil {
+		return "", fmt.Errorf("fetching inclusion proof: %w", err)
+	}
+	tree.Metadata.TransparencyProofs = append(tree.Metadata.TransparencyProofs, TransparencyProof{
+		InclusionProof: proof,
+		SignedTreeHead: sth,
+	})
+	return digest, nil
+}
+
+func keySourceFingerprints(sources []KeySource) []string {
+	var fingerprints []string
+	for _, source := range sources {
+		for _, k := range source.Keys {
+			fingerprints = append(fingerprints, k.ToString())
+		}
+	}
+	return fingerprints
+}
@@ -0,0 +1,78 @@
+package sops
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+
+	"go.mozilla.org/sops/transparency"
+)
+
+// VerifyTransparency checks t's most recent TransparencyProof (as stored
+// by EncryptAndLog): that its signed tree head is signed by one of
+// trustedKeys, and that t's current Merkle root is included in the log at
+// the size that signed tree head commits to. fileID must be the same
+// identifier the entry was appended under. It returns an error if the
+// file's data has been rewritten since the encrypt recorded in the log.
+// It checks only the proofs stored in t and doesn't consult the log, so a
+// file rolled back together with its Metadata to an older logged version
+// still verifies.
+func (t Tree) VerifyTransparency(fileID string, trustedKeys []ed25519.PublicKey) error {
+	if len(t.Metadata.TransparencyProofs) == 0 {
+		return fmt.Errorf("tree has no transparency proofs to verify")
+	}
+	tp := t.Metadata.TransparencyProofs[len(t.Metadata.TransparencyProofs)-1]
+
+	if !verifySTHSignature(tp.SignedTreeHead, trustedKeys) {
+		return fmt.Errorf("signed tree head at size %d is not signed by a trusted key", tp.SignedTreeHead.Size)
+	}
+
+	root, err := t.MerkleRoot(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("computing tree's Merkle root: %w", err)
+	}
+
+	entry := transparency.Entry{FileID: fileID, MerkleRoot: root}
+	if !verifyLogInclusion(entry, tp.InclusionProof, tp.SignedTreeHead) {
+		return fmt.Errorf("tree's Merkle root is not included in the transparency log at index %d", tp.InclusionProof.LeafIndex)
+	}
+	return nil
+}
+
+func verifySTHSignature(sth transparency.SignedTreeHead, trustedKeys []ed25519.PublicKey) bool {
+	for _, key := range trustedKeys {
+		if ed25519.Verify(key, signableSTHBytes(sth), sth.Signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// signableSTHBytes must match the encoding used when the tree head was
+// signed, so VerifyTransparency and the Log implementation that produced
+// the signature agree on what was actually signed.
+func signableSTHBytes(sth transparency.SignedTreeHead) []byte {
+	b := make([]byte, 0, len(sth.RootHash)+16)
+	b = append(b, byte(sth.Size>>56), byte(sth.Size>>48), byte(sth.Size>>40), byte(sth.Size>>32),
+		byte(sth.Size>>24), byte(sth.Size>>16), byte(sth.Size>>8), byte(sth.Size))
+	ts := sth.Timestamp.UnixNano()
+	b = append(b, byte(ts>>56), byte(ts>>48), byte(ts>>40), byte(ts>>32),
+		byte(ts>>24), byte(ts>>16), byte(ts>>8), byte(ts))
+	b = append(b, sth.RootHash...)
+	return b
+}
+
+func verifyLogInclusion(entry transparency.Entry, proof transparency.InclusionProof, sth transparency.SignedTreeHead) bool {
+	current := transparency.LeafHash(entry)
+	index := proof.LeafIndex
+	for _, sibling := range proof.Hashes {
+		if index%2 == 0 {
+			current = hashNode(crypto.SHA256, current, sibling)
+		} else {
+			current = hashNode(crypto.SHA256, sibling, current)
+		}
+		index = index / 2
+	}
+	return bytes.Equal(current, sth.RootHash)
+}
@@ -0,0 +1,62 @@
+package sops
+
+// DefaultUnencryptedSuffix is the conventional suffix appended to a key to
+// leave its value unencrypted, for callers to set as
+// Metadata.UnencryptedSuffix. It isn't applied automatically: a Metadata
+// with no selector set encrypts every leaf.
+const DefaultUnencryptedSuffix = "_unencrypted"
+
+// TreeItem is a single key/value pair in a TreeBranch. Using a slice of
+// TreeItem rather than a map preserves the ordering of keys in the
+// source document.
+type TreeItem struct {
+	Key   string
+	Value interface{}
+}
+
+// TreeBranch represents one level of a sops tree: an ordered list of
+// TreeItem. A TreeItem's Value may itself be a TreeBranch, in which case
+// it's a nested object rather than a leaf.
+type TreeBranch []TreeItem
+
+// Tree is a whole sops file: its data, and the Metadata describing how
+// that data is encrypted.
+type Tree struct {
+	Branch   TreeBranch
+	Metadata Metadata
+}
+
+// MasterKey is implemented by each supported key management backend
+// (KMS, PGP, ...) to encrypt and decrypt the tree's data key.
+type MasterKey interface {
+	Encrypt(dataKey []byte) error
+	EncryptIfNeeded(dataKey []byte) error
+	Decrypt() ([]byte, error)
+	NeedsRotation() bool
+	ToString() string
+	ToMap() map[string]interface{}
+}
+
+// KeySource groups a set of MasterKeys under a name, e.g. "kms" or "pgp".
+type KeySource struct {
+	Name string
+	Keys []MasterKey
+}
+
+// RemoveMasterKeys removes every key in toRemove from m's KeySources,
+// matching keys by their ToString() representation.
+func (m *Metadata) RemoveMasterKeys(toRemove []MasterKey) {
+	remove := make(map[string]bool, len(toRemove))
+	for _, k := range toRemove {
+		remove[k.ToString()] = true
+	}
+	for i, source := range m.KeySources {
+		kept := source.Keys[:0]
+		for _, k := range source.Keys {
+			if !remove[k.ToString()] {
+				kept = append(kept, k)
+			}
+		}
+		m.KeySources[i].Keys = kept
+	}
+}